@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/interfaces"
+	"github.com/ledgerwatch/erigon/eth/filters"
+	"github.com/ledgerwatch/erigon/eth/stagedsync"
+)
+
+// ErigonImpl implements the erigon_* RPC namespace. Only the fields the
+// erigon_issuance*.go handlers need are declared here; eth_*-namespace
+// concerns such as getReceipts/getBlockNumber live alongside their own
+// handlers elsewhere in this package.
+type ErigonImpl struct {
+	db           kv.RoDB
+	_blockReader interfaces.FullBlockReader
+	filters      *filters.Filters
+
+	// _issuanceCalculator and _includeBlobBurntInTotalBurnt mirror the same
+	// fields on eth/stagedsync.IssuanceCfg, so erigon_issuance/erigon_supply
+	// agree with the issuance stage on reward accounting and the blob-burn
+	// double-count rule. See NewErigonAPI.
+	_issuanceCalculator           stagedsync.IssuanceCalculator
+	_includeBlobBurntInTotalBurnt bool
+}
+
+// NewErigonAPI builds the erigon_* namespace implementation. calculator
+// should be the same value passed to StageIssuanceCfg for this chain, and
+// includeBlobBurntInTotalBurnt the value of IssuanceCfg's field of that name,
+// so the RPC layer and the issuance stage agree. StageIssuanceCfg takes no
+// such option and leaves that field false.
+func NewErigonAPI(db kv.RoDB, blockReader interfaces.FullBlockReader, f *filters.Filters, calculator stagedsync.IssuanceCalculator, includeBlobBurntInTotalBurnt bool) *ErigonImpl {
+	return &ErigonImpl{
+		db:                            db,
+		_blockReader:                  blockReader,
+		filters:                       f,
+		_issuanceCalculator:           calculator,
+		_includeBlobBurntInTotalBurnt: includeBlobBurntInTotalBurnt,
+	}
+}
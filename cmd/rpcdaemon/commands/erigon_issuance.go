@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/erigon-lib/common/hexutil"
+	"github.com/ledgerwatch/erigon/consensus/ethash"
+	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/eth/stagedsync"
+	"github.com/ledgerwatch/erigon/rpc"
+)
+
+// IssuanceReport is the per-block breakdown returned by erigon_issuance.
+// All big.Int fields are wei; a nil field means the figure isn't reported
+// for this block: blockReward/uncleReward are only filled for pre-merge
+// blocks when the ethash calculator is configured, and are nil otherwise.
+type IssuanceReport struct {
+	Issuance    *hexutil.Big `json:"issuance"`
+	BlockReward *hexutil.Big `json:"blockReward"`
+	UncleReward *hexutil.Big `json:"uncleReward"`
+	Burnt       *hexutil.Big `json:"burnt"`
+	Tips        *hexutil.Big `json:"tips"`
+	BlobBurnt   *hexutil.Big `json:"blobBurnt"`
+	Withdrawn   *hexutil.Big `json:"withdrawn"`
+	TotalSupply *hexutil.Big `json:"totalSupply"`
+}
+
+// Issuance implements erigon_issuance. It reports the supply-affecting deltas
+// for a single block: what SpawnStageIssuance added to each running total
+// between block n-1 and block n, plus a convenience blockReward/uncleReward
+// split re-derived from the header for ethash blocks, and a tips figure
+// summed from the block's receipts.
+func (api *ErigonImpl) Issuance(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (IssuanceReport, error) {
+	tx, err := api.db.BeginRo(ctx)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	defer tx.Rollback()
+
+	header, err := headerByNumberOrHash(ctx, tx, blockNrOrHash, api)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	if header == nil {
+		return IssuanceReport{}, fmt.Errorf("header not found for %s", blockNrOrHash.String())
+	}
+	blockNumber := header.Number.Uint64()
+
+	issuanceDelta, err := deltaBig(tx, blockNumber, rawdb.ReadTotalIssued)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	burntDelta, err := deltaBig(tx, blockNumber, rawdb.ReadTotalBurnt)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	blobBurntDelta, err := deltaBig(tx, blockNumber, rawdb.ReadTotalBlobBurnt)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	withdrawnDelta, err := deltaBig(tx, blockNumber, rawdb.ReadTotalWithdrawn)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+
+	report := IssuanceReport{
+		Issuance:  (*hexutil.Big)(issuanceDelta),
+		Burnt:     (*hexutil.Big)(burntDelta),
+		BlobBurnt: (*hexutil.Big)(blobBurntDelta),
+		Withdrawn: (*hexutil.Big)(withdrawnDelta),
+	}
+
+	// The blockReward/uncleReward split is ethash-specific (it's the only
+	// engine that pays uncles separately); route it through the same
+	// IssuanceCalculator the stage uses rather than guessing the engine from
+	// header.Difficulty, which is also nonzero on Clique/Bor/AuRa chains.
+	// Other engines leave both fields nil, same as post-merge blocks.
+	if ethashCalc, ok := api._issuanceCalculator.(stagedsync.EthashIssuanceCalculator); ok && header.Difficulty != nil && header.Difficulty.Sign() != 0 {
+		body, _, err := api._blockReader.BodyWithTransactions(ctx, tx, header.Hash(), blockNumber)
+		if err != nil {
+			return IssuanceReport{}, err
+		}
+		var uncles []*types.Header
+		if body != nil {
+			uncles = body.Uncles
+		}
+		blockReward, uncleRewards := ethash.AccumulateRewards(ethashCalc.ChainConfig, header, uncles)
+		uncleTotal := new(big.Int)
+		for _, r := range uncleRewards {
+			uncleTotal.Add(uncleTotal, r.ToBig())
+		}
+		report.BlockReward = (*hexutil.Big)(blockReward.ToBig())
+		report.UncleReward = (*hexutil.Big)(uncleTotal)
+	}
+
+	tips, err := blockTips(ctx, tx, api, header, blockNumber)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	report.Tips = (*hexutil.Big)(tips)
+
+	totalSupply, err := computeSupply(tx, blockNumber, api._includeBlobBurntInTotalBurnt)
+	if err != nil {
+		return IssuanceReport{}, err
+	}
+	report.TotalSupply = (*hexutil.Big)(totalSupply)
+
+	return report, nil
+}
+
+// CumulativeIssuance implements erigon_cumulativeIssuance: the running total
+// of ether minted up to and including blockNrOrHash.
+func (api *ErigonImpl) CumulativeIssuance(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	tx, err := api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	header, err := headerByNumberOrHash(ctx, tx, blockNrOrHash, api)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("header not found for %s", blockNrOrHash.String())
+	}
+	totalIssued, err := rawdb.ReadTotalIssued(tx, header.Number.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(totalIssued), nil
+}
+
+// Supply implements erigon_supply: genesisAlloc + totalIssued - totalBurnt,
+// less totalBlobBurnt when it isn't already folded into totalBurnt (see
+// computeSupply).
+func (api *ErigonImpl) Supply(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	tx, err := api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	header, err := headerByNumberOrHash(ctx, tx, blockNrOrHash, api)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("header not found for %s", blockNrOrHash.String())
+	}
+	totalSupply, err := computeSupply(tx, header.Number.Uint64(), api._includeBlobBurntInTotalBurnt)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(totalSupply), nil
+}
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/rpc"
+)
+
+// headerByNumberOrHash resolves an rpc.BlockNumberOrHash the same way the
+// rest of this package's eth_* handlers do, returning nil (not an error) if
+// the block simply isn't known yet.
+func headerByNumberOrHash(ctx context.Context, tx kv.Tx, blockNrOrHash rpc.BlockNumberOrHash, api *ErigonImpl) (*types.Header, error) {
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		return api._blockReader.HeaderByHash(ctx, tx, hash)
+	}
+	blockNumber, err := getBlockNumber(blockNrOrHash, tx, api.filters)
+	if err != nil {
+		return nil, err
+	}
+	return api._blockReader.HeaderByNumber(ctx, tx, blockNumber)
+}
+
+// deltaBig computes read(n) - read(n-1), which is how every per-block
+// issuance/burn/withdrawal figure is derived from the cumulative counters
+// SpawnStageIssuance maintains.
+func deltaBig(tx kv.Tx, blockNumber uint64, read func(kv.Tx, uint64) (*big.Int, error)) (*big.Int, error) {
+	current, err := read(tx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if blockNumber == 0 {
+		return current, nil
+	}
+	previous, err := read(tx, blockNumber-1)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Sub(current, previous), nil
+}
+
+// blockTips sums (effectiveGasPrice - baseFee) * gasUsed across the block's
+// receipts, i.e. the portion of fees that went to the block proposer rather
+// than being burnt.
+func blockTips(ctx context.Context, tx kv.Tx, api *ErigonImpl, header *types.Header, blockNumber uint64) (*big.Int, error) {
+	receipts, err := api.getReceipts(ctx, tx, header, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	tips := new(big.Int)
+	baseFee := big.NewInt(0)
+	if header.BaseFee != nil {
+		baseFee = header.BaseFee
+	}
+	for _, r := range receipts {
+		tip := new(big.Int).Sub(r.EffectiveGasPrice, baseFee)
+		if tip.Sign() < 0 {
+			continue
+		}
+		tip.Mul(tip, new(big.Int).SetUint64(r.GasUsed))
+		tips.Add(tips, tip)
+	}
+	return tips, nil
+}
+
+// computeSupply returns genesisAlloc + totalIssued - totalBurnt - totalBlobBurnt
+// at blockNumber. Blob burn is always real destroyed ether, so it always comes
+// off supply; blobBurntIncludedInTotalBurnt only tells us whether totalBurnt
+// (per IssuanceCfg.includeBlobBurntInTotalBurnt) already folds it in, so we
+// don't subtract it twice for chains that enabled that legacy option.
+func computeSupply(tx kv.Tx, blockNumber uint64, blobBurntIncludedInTotalBurnt bool) (*big.Int, error) {
+	totalIssued, err := rawdb.ReadTotalIssued(tx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	totalBurnt, err := rawdb.ReadTotalBurnt(tx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	totalBlobBurnt, err := rawdb.ReadTotalBlobBurnt(tx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	genesisAlloc, err := rawdb.ReadGenesisAllocSum(tx)
+	if err != nil {
+		return nil, err
+	}
+	supply := new(big.Int).Add(genesisAlloc, totalIssued)
+	supply.Sub(supply, totalBurnt)
+	if !blobBurntIncludedInTotalBurnt {
+		supply.Sub(supply, totalBlobBurnt)
+	}
+	return supply, nil
+}
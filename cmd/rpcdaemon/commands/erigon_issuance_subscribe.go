@@ -0,0 +1,243 @@
+package commands
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ledgerwatch/erigon-lib/common/hexutil"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon/common"
+	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/rpc"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// IssuanceDelta is what erigon_subscribe("issuance") streams on every new
+// head: the per-block change in total supply. On a reorg, the blocks that
+// get unwound are replayed with their Issuance/Burnt negated before the new
+// canonical chain's deltas are sent, so the deltas a subscriber has received
+// sum to the change in TotalIssued and TotalBurnt along the current canonical
+// chain since the first block sent. They leave out the genesis allocation
+// and any blob burn kept outside TotalBurnt, both of which erigon_supply
+// counts.
+type IssuanceDelta struct {
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	Issuance    *hexutil.Big   `json:"issuance"`
+	Burnt       *hexutil.Big   `json:"burnt"`
+	Reverted    bool           `json:"reverted"`
+}
+
+// issuanceSent is the payload of a previously-sent, not-yet-reverted
+// IssuanceDelta, cached so a later reorg can replay exactly what was sent
+// instead of re-deriving it from rawdb: UnwindIssuanceStage deletes the
+// TotalIssued/TotalBurnt rows for unwound blocks, so by the time this
+// subscription reacts to the reorg those rows are already gone.
+type issuanceSent struct {
+	blockHash common.Hash
+	issuance  *big.Int
+	burnt     *big.Int
+}
+
+// issuanceChainBlock is the minimal per-block info issuanceReconcile needs to
+// walk a chain backward: its own (number, hash) and its parent's hash.
+type issuanceChainBlock struct {
+	number     uint64
+	hash       common.Hash
+	parentHash common.Hash
+}
+
+// issuanceReconcile decides, for a new canonical head, which previously-sent
+// block numbers are no longer canonical (toRevert, newest-first) and which
+// block numbers need a delta sent (toSend, oldest-first) - by walking head's
+// ancestry via ancestorOf and comparing canonical *hashes* against
+// sentHashes at each height, not by comparing block numbers. Comparing
+// numbers alone misses the common case of a reorg onto a taller canonical
+// chain, where head.number is already greater than anything sent before.
+//
+// Sent blocks above head's height are reverted without walking to them.
+// The walk stops as soon as it finds a height whose cached hash matches
+// (the common ancestor), or once it has checked lowestSent, the lowest block
+// number ever sent - below that we have no cached history to compare
+// against, so there's nothing more to revert.
+func issuanceReconcile(head issuanceChainBlock, sentHashes map[uint64]common.Hash, lowestSent uint64, haveSent bool, ancestorOf func(parentHash common.Hash, number uint64) (issuanceChainBlock, bool)) (toRevert, toSend []uint64) {
+	if !haveSent {
+		return nil, []uint64{head.number}
+	}
+
+	// Blocks sent above the new head's height are no longer canonical,
+	// whatever the walk below finds (a reorg onto a shorter chain).
+	highest := head.number
+	for n := range sentHashes {
+		if n > highest {
+			highest = n
+		}
+	}
+	for n := highest; n > head.number; n-- {
+		if _, ok := sentHashes[n]; ok {
+			toRevert = append(toRevert, n)
+		}
+	}
+
+	cur := head
+	for {
+		if hash, ok := sentHashes[cur.number]; ok && hash == cur.hash {
+			break
+		}
+		toSend = append(toSend, cur.number)
+		if _, ok := sentHashes[cur.number]; ok {
+			toRevert = append(toRevert, cur.number)
+		}
+		if cur.number <= lowestSent {
+			break
+		}
+		parent, ok := ancestorOf(cur.parentHash, cur.number-1)
+		if !ok {
+			break
+		}
+		cur = parent
+	}
+
+	for i, j := 0, len(toSend)-1; i < j; i, j = i+1, j-1 {
+		toSend[i], toSend[j] = toSend[j], toSend[i]
+	}
+	return toRevert, toSend
+}
+
+// issuanceSubscriptionState tracks, for one erigon_subscribe("issuance")
+// connection, every delta actually sent, so reorgs can be reconciled against
+// it via issuanceReconcile.
+type issuanceSubscriptionState struct {
+	sent       map[uint64]issuanceSent
+	lowestSent uint64
+	haveSent   bool
+}
+
+func newIssuanceSubscriptionState() *issuanceSubscriptionState {
+	return &issuanceSubscriptionState{sent: make(map[uint64]issuanceSent)}
+}
+
+func (st *issuanceSubscriptionState) sentHashes() map[uint64]common.Hash {
+	hashes := make(map[uint64]common.Hash, len(st.sent))
+	for n, s := range st.sent {
+		hashes[n] = s.blockHash
+	}
+	return hashes
+}
+
+// sync brings the subscriber from whatever chain st currently reflects to
+// the canonical chain ending at head.
+func (st *issuanceSubscriptionState) sync(ctx context.Context, tx kv.Tx, api *ErigonImpl, rpcSub *rpc.Subscription, head *types.Header) error {
+	ancestorOf := func(parentHash common.Hash, number uint64) (issuanceChainBlock, bool) {
+		h, err := api._blockReader.Header(ctx, tx, parentHash, number)
+		if err != nil || h == nil {
+			return issuanceChainBlock{}, false
+		}
+		return issuanceChainBlock{number: number, hash: h.Hash(), parentHash: h.ParentHash}, true
+	}
+
+	toRevert, toSend := issuanceReconcile(
+		issuanceChainBlock{number: head.Number.Uint64(), hash: head.Hash(), parentHash: head.ParentHash},
+		st.sentHashes(), st.lowestSent, st.haveSent, ancestorOf)
+
+	for _, n := range toRevert {
+		if err := st.revert(rpcSub, n); err != nil {
+			return err
+		}
+	}
+	for _, n := range toSend {
+		if err := st.send(tx, rpcSub, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *issuanceSubscriptionState) send(tx kv.Tx, rpcSub *rpc.Subscription, blockNumber uint64) error {
+	issuanceDelta, err := deltaBig(tx, blockNumber, rawdb.ReadTotalIssued)
+	if err != nil {
+		return err
+	}
+	burntDelta, err := deltaBig(tx, blockNumber, rawdb.ReadTotalBurnt)
+	if err != nil {
+		return err
+	}
+	hash, err := rawdb.ReadCanonicalHash(tx, blockNumber)
+	if err != nil {
+		return err
+	}
+
+	st.sent[blockNumber] = issuanceSent{blockHash: hash, issuance: issuanceDelta, burnt: burntDelta}
+	if !st.haveSent || blockNumber < st.lowestSent {
+		st.lowestSent = blockNumber
+		st.haveSent = true
+	}
+	return rpcSub.Notify(rpcSub.ID, IssuanceDelta{
+		BlockNumber: hexutil.Uint64(blockNumber),
+		BlockHash:   hash,
+		Issuance:    (*hexutil.Big)(issuanceDelta),
+		Burnt:       (*hexutil.Big)(burntDelta),
+		Reverted:    false,
+	})
+}
+
+// revert replays the cached delta for blockNumber, negated, and removes it
+// from the cache.
+func (st *issuanceSubscriptionState) revert(rpcSub *rpc.Subscription, blockNumber uint64) error {
+	s, ok := st.sent[blockNumber]
+	if !ok {
+		return nil
+	}
+	delete(st.sent, blockNumber)
+	return rpcSub.Notify(rpcSub.ID, IssuanceDelta{
+		BlockNumber: hexutil.Uint64(blockNumber),
+		BlockHash:   s.blockHash,
+		Issuance:    (*hexutil.Big)(new(big.Int).Neg(s.issuance)),
+		Burnt:       (*hexutil.Big)(new(big.Int).Neg(s.burnt)),
+		Reverted:    true,
+	})
+}
+
+// Subscribe implements erigon_subscribe. Only the "issuance" topic is
+// currently supported; unknown topics return an error rather than silently
+// subscribing to nothing.
+func (api *ErigonImpl) Subscribe(ctx context.Context, topic string) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	if topic != "issuance" {
+		return nil, rpc.ErrSubscriptionNotFound
+	}
+
+	rpcSub := notifier.CreateSubscription()
+	headers := make(chan *types.Header, 16)
+	unsubscribeHeaders := api.filters.SubscribeNewHeads(headers)
+
+	go func() {
+		defer api.filters.UnsubscribeHeads(unsubscribeHeaders)
+		st := newIssuanceSubscriptionState()
+
+		for {
+			select {
+			case header := <-headers:
+				tx, err := api.db.BeginRo(ctx)
+				if err != nil {
+					log.Warn("erigon_subscribe(issuance): begin tx", "err", err)
+					continue
+				}
+				if err := st.sync(ctx, tx, api, rpcSub, header); err != nil {
+					log.Warn("erigon_subscribe(issuance): sync", "block", header.Number.Uint64(), "err", err)
+				}
+				tx.Rollback()
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ledgerwatch/erigon/common"
+)
+
+func hash(b byte) common.Hash {
+	var h common.Hash
+	h[0] = b
+	return h
+}
+
+func buildFakeAncestorOf(chain map[common.Hash]issuanceChainBlock) func(common.Hash, uint64) (issuanceChainBlock, bool) {
+	return func(parentHash common.Hash, number uint64) (issuanceChainBlock, bool) {
+		b, ok := chain[parentHash]
+		if !ok || b.number != number {
+			return issuanceChainBlock{}, false
+		}
+		return b, true
+	}
+}
+
+func TestIssuanceReconcileFirstHeadBootstraps(t *testing.T) {
+	head := issuanceChainBlock{number: 5, hash: hash(5)}
+	toRevert, toSend := issuanceReconcile(head, map[uint64]common.Hash{}, 0, false, nil)
+	if len(toRevert) != 0 {
+		t.Fatalf("bootstrap case must not revert anything, got %v", toRevert)
+	}
+	if !reflect.DeepEqual(toSend, []uint64{5}) {
+		t.Fatalf("bootstrap case must send just the head, got %v", toSend)
+	}
+}
+
+func TestIssuanceReconcileSimpleExtension(t *testing.T) {
+	head := issuanceChainBlock{number: 6, hash: hash(6), parentHash: hash(5)}
+	sentHashes := map[uint64]common.Hash{5: hash(5)}
+	ancestorOf := buildFakeAncestorOf(map[common.Hash]issuanceChainBlock{
+		hash(5): {number: 5, hash: hash(5)},
+	})
+
+	toRevert, toSend := issuanceReconcile(head, sentHashes, 5, true, ancestorOf)
+	if len(toRevert) != 0 {
+		t.Fatalf("a plain extension must not revert anything, got %v", toRevert)
+	}
+	if !reflect.DeepEqual(toSend, []uint64{6}) {
+		t.Fatalf("a plain extension must send just the new head, got %v", toSend)
+	}
+}
+
+// TestIssuanceReconcileTallerReorg is the case review comment 4 flagged:
+// the new head's number is greater than anything sent before, but it's on a
+// different fork than what was cached - comparing block numbers alone would
+// never notice. Old chain: 8 -> 9 -> 10 (sent). New chain reorgs at 8: 8 ->
+// 9' -> 10' -> 11' -> 12', taller than the old head.
+func TestIssuanceReconcileTallerReorg(t *testing.T) {
+	sentHashes := map[uint64]common.Hash{
+		8:  hash(8),
+		9:  hash(9),
+		10: hash(10),
+	}
+
+	head := issuanceChainBlock{number: 12, hash: hash(0x1c), parentHash: hash(0x1b)}
+	ancestorOf := buildFakeAncestorOf(map[common.Hash]issuanceChainBlock{
+		hash(0x1b): {number: 11, hash: hash(0x1b), parentHash: hash(0x1a)},
+		hash(0x1a): {number: 10, hash: hash(0x1a), parentHash: hash(0x19)},
+		hash(0x19): {number: 9, hash: hash(0x19), parentHash: hash(8)},
+		hash(8):    {number: 8, hash: hash(8)},
+	})
+
+	toRevert, toSend := issuanceReconcile(head, sentHashes, 8, true, ancestorOf)
+
+	if !reflect.DeepEqual(toRevert, []uint64{10, 9}) {
+		t.Fatalf("expected to revert stale blocks 10,9 newest-first, got %v", toRevert)
+	}
+	if !reflect.DeepEqual(toSend, []uint64{9, 10, 11, 12}) {
+		t.Fatalf("expected to send new blocks 9..12 oldest-first, got %v", toSend)
+	}
+}
+
+func TestIssuanceReconcileStopsAtCacheFloor(t *testing.T) {
+	sentHashes := map[uint64]common.Hash{5: hash(5)}
+	// No ancestor data below block 5: a reorg deeper than our cached history
+	// should stop there rather than fabricating reverts for blocks we never
+	// sent.
+	head := issuanceChainBlock{number: 5, hash: hash(0x15), parentHash: hash(4)}
+
+	toRevert, toSend := issuanceReconcile(head, sentHashes, 5, true, func(common.Hash, uint64) (issuanceChainBlock, bool) {
+		t.Fatal("must not walk past the cache floor")
+		return issuanceChainBlock{}, false
+	})
+
+	if !reflect.DeepEqual(toRevert, []uint64{5}) {
+		t.Fatalf("expected to revert stale block 5, got %v", toRevert)
+	}
+	if !reflect.DeepEqual(toSend, []uint64{5}) {
+		t.Fatalf("expected to send new block 5, got %v", toSend)
+	}
+}
@@ -0,0 +1,72 @@
+package rawdb
+
+import (
+	"math/big"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon/common/dbutils"
+)
+
+// ReadTotalWithdrawn and WriteTotalWithdrawn track the cumulative ether
+// credited to validators via EIP-4895 withdrawals, mirroring
+// ReadTotalIssued/WriteTotalIssued so the issuance stage and the RPC layer
+// that reads its output share one encoding.
+func ReadTotalWithdrawn(tx kv.Tx, blockNumber uint64) (*big.Int, error) {
+	v, err := tx.GetOne(kv.TotalWithdrawn, dbutils.EncodeBlockNumber(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).SetBytes(v), nil
+}
+
+func WriteTotalWithdrawn(tx kv.RwTx, blockNumber uint64, total *big.Int) error {
+	return tx.Put(kv.TotalWithdrawn, dbutils.EncodeBlockNumber(blockNumber), total.Bytes())
+}
+
+// ReadTotalBlobBurnt and WriteTotalBlobBurnt track the cumulative ether
+// burnt by EIP-4844 blob gas, kept separate from TotalBurnt so callers can
+// still see the split between execution and blob fee burns.
+func ReadTotalBlobBurnt(tx kv.Tx, blockNumber uint64) (*big.Int, error) {
+	v, err := tx.GetOne(kv.TotalBlobBurnt, dbutils.EncodeBlockNumber(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).SetBytes(v), nil
+}
+
+func WriteTotalBlobBurnt(tx kv.RwTx, blockNumber uint64, total *big.Int) error {
+	return tx.Put(kv.TotalBlobBurnt, dbutils.EncodeBlockNumber(blockNumber), total.Bytes())
+}
+
+// genesisAllocSumKey is the single row key under which the sum of the
+// genesis allocation (in wei) is cached, so computeSupply doesn't have to
+// re-sum genesis.Alloc on every erigon_supply/erigon_issuance call.
+var genesisAllocSumKey = []byte("genesisAllocSum")
+
+// ReadGenesisAllocSum and WriteGenesisAllocSum track the total wei allocated
+// in the genesis block, the baseline computeSupply adds TotalIssued to and
+// subtracts TotalBurnt/TotalBlobBurnt from. WriteGenesisAllocSum is meant to
+// be called once, alongside the rest of genesis initialization, by summing
+// genesis.Alloc balances; until that's wired up for a given chain,
+// ReadGenesisAllocSum returns zero rather than an error so the supply RPCs
+// stay usable (at the cost of an understated total) instead of failing outright.
+func ReadGenesisAllocSum(tx kv.Tx) (*big.Int, error) {
+	v, err := tx.GetOne(kv.GenesisAllocSum, genesisAllocSumKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).SetBytes(v), nil
+}
+
+func WriteGenesisAllocSum(tx kv.RwTx, total *big.Int) error {
+	return tx.Put(kv.GenesisAllocSum, genesisAllocSumKey, total.Bytes())
+}
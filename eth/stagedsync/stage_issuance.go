@@ -1,38 +1,69 @@
 package stagedsync
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"math/big"
 	"time"
 
-	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/interfaces"
 	"github.com/ledgerwatch/erigon/common"
 	"github.com/ledgerwatch/erigon/common/dbutils"
-	"github.com/ledgerwatch/erigon/consensus/ethash"
-	"github.com/ledgerwatch/erigon/consensus/serenity"
 	"github.com/ledgerwatch/erigon/core/rawdb"
-	"github.com/ledgerwatch/erigon/core/types"
 	"github.com/ledgerwatch/erigon/eth/stagedsync/stages"
 	"github.com/ledgerwatch/erigon/params"
-	"github.com/ledgerwatch/erigon/rlp"
 	"github.com/ledgerwatch/log/v3"
 )
 
+// issuanceChunkSize is the window size each worker claims at a time when
+// computing per-block issuance/burn; large enough to amortize the read-tx
+// and goroutine-scheduling overhead, small enough to keep workers balanced.
+const issuanceChunkSize = 8192
+
+// gweiToWei converts an EIP-4895 withdrawal amount (denominated in gwei) to wei.
+var gweiToWei = big.NewInt(1e9)
+
+// EIP-4844 blob gas pricing constants.
+var (
+	minBlobBaseFee            = big.NewInt(1)
+	blobBaseFeeUpdateFraction = big.NewInt(3338477)
+)
+
+// mergeTransitionKey is the single row key under which the cached merge
+// transition block number is stored, so repeated SpawnStageIssuance calls
+// don't have to reinterpret total-difficulty for every header once it's known.
+var mergeTransitionKey = []byte("t")
+
+// mergeScanProgressKey is the single row key recording how far
+// resolveMergeTransitionBlock has already scanned total difficulties
+// looking for the TTD crossing, so a chain that's still below TTD doesn't
+// get rescanned from genesis on every SpawnStageIssuance call.
+var mergeScanProgressKey = []byte("s")
+
 type IssuanceCfg struct {
 	db          kv.RwDB
 	chainConfig *params.ChainConfig
 	blockReader interfaces.FullBlockReader
+	// pruneDistance is how many blocks of TotalIssued/TotalBurnt history to
+	// retain behind the chain tip; 0 means keep everything (full node default).
+	pruneDistance uint64
+	// includeBlobBurntInTotalBurnt folds TotalBlobBurnt into TotalBurnt for
+	// callers that haven't migrated to reading the blob counter separately.
+	// StageIssuanceCfg leaves it false.
+	includeBlobBurntInTotalBurnt bool
+	// calculator computes the pre-merge block reward and burn for the
+	// chain's consensus engine; see IssuanceCalculator.
+	calculator IssuanceCalculator
 }
 
-func StageIssuanceCfg(db kv.RwDB, chainConfig *params.ChainConfig, blockReader interfaces.FullBlockReader) IssuanceCfg {
+func StageIssuanceCfg(db kv.RwDB, chainConfig *params.ChainConfig, blockReader interfaces.FullBlockReader, calculator IssuanceCalculator, pruneDistance uint64) IssuanceCfg {
 	return IssuanceCfg{
-		db:          db,
-		chainConfig: chainConfig,
-		blockReader: blockReader,
+		db:            db,
+		chainConfig:   chainConfig,
+		blockReader:   blockReader,
+		calculator:    calculator,
+		pruneDistance: pruneDistance,
 	}
 }
 
@@ -56,17 +87,6 @@ func SpawnStageIssuance(cfg IssuanceCfg, s *StageState, tx kv.RwTx, ctx context.
 	if headNumber == s.BlockNumber {
 		return nil
 	}
-	if cfg.chainConfig.Consensus != params.EtHashConsensus {
-		if err = s.Update(tx, headNumber); err != nil {
-			return err
-		}
-		if !useExternalTx {
-			if err = tx.Commit(); err != nil {
-				return err
-			}
-		}
-		return nil
-	}
 	// Log timer
 	logEvery := time.NewTicker(logInterval)
 	defer logEvery.Stop()
@@ -81,111 +101,204 @@ func SpawnStageIssuance(cfg IssuanceCfg, s *StageState, tx kv.RwTx, ctx context.
 		return err
 	}
 
-	stopped := false
+	totalWithdrawn, err := rawdb.ReadTotalWithdrawn(tx, s.BlockNumber)
+	if err != nil {
+		return err
+	}
+
+	totalBlobBurnt, err := rawdb.ReadTotalBlobBurnt(tx, s.BlockNumber)
+	if err != nil {
+		return err
+	}
+
+	mergeBlock, mergeKnown, err := resolveMergeTransitionBlock(tx, cfg, headNumber)
+	if err != nil {
+		return fmt.Errorf("resolving merge transition block: %w", err)
+	}
+
+	from := s.BlockNumber + 1
 	prevProgress := s.BlockNumber
-	currentBlockNumber := s.BlockNumber + 1
-	headerC, err := tx.Cursor(kv.Headers)
+	lastWritten := s.BlockNumber
+	stopped := false
+
+	// streamIssuanceRange computes ahead of us in fixed-size windows, but we
+	// fold each window into the running totals and write/log it as soon as
+	// it's ready - so memory stays bounded to a handful of in-flight windows
+	// and the log cadence below still fires throughout a long catch-up,
+	// rather than only once the entire range has been computed.
+	err = streamIssuanceRange(ctx, cfg, mergeBlock, mergeKnown, from, headNumber, func(start uint64, results []issuanceBlockResult) error {
+		for i, r := range results {
+			if stopped {
+				return nil
+			}
+			currentBlockNumber := start + uint64(i)
+
+			totalIssued.Add(totalIssued, r.blockReward)
+			totalBurnt.Add(totalBurnt, r.burnt)
+			totalBlobBurnt.Add(totalBlobBurnt, r.blobBurnt)
+			if cfg.includeBlobBurntInTotalBurnt {
+				totalBurnt.Add(totalBurnt, r.blobBurnt)
+			}
+			totalWithdrawn.Add(totalWithdrawn, r.withdrawn)
+
+			if err := rawdb.WriteTotalIssued(tx, currentBlockNumber, totalIssued); err != nil {
+				return err
+			}
+			if err := rawdb.WriteTotalBurnt(tx, currentBlockNumber, totalBurnt); err != nil {
+				return err
+			}
+			if err := rawdb.WriteTotalWithdrawn(tx, currentBlockNumber, totalWithdrawn); err != nil {
+				return err
+			}
+			if err := rawdb.WriteTotalBlobBurnt(tx, currentBlockNumber, totalBlobBurnt); err != nil {
+				return err
+			}
+			lastWritten = currentBlockNumber
+
+			select {
+			case <-ctx.Done():
+				stopped = true
+			case <-logEvery.C:
+				log.Info(fmt.Sprintf("[%s] Wrote Block Issuance", s.LogPrefix()),
+					"now", currentBlockNumber, "blk/sec", float64(currentBlockNumber-prevProgress)/float64(logInterval/time.Second))
+				prevProgress = currentBlockNumber
+			default:
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	for k, v, err := headerC.Seek(dbutils.EncodeBlockNumber(currentBlockNumber)); k != nil && !stopped; k, v, err = headerC.Next() {
-		if err != nil {
+
+	if err = s.Update(tx, lastWritten); err != nil {
+		return err
+	}
+	if !useExternalTx {
+		if err = tx.Commit(); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// resolveMergeTransitionBlock returns the block number at which the chain
+// crossed TerminalTotalDifficulty, caching it in kv.MergeTransitionBlock once
+// found so later calls don't need to walk total difficulties again. The bool
+// return is false for chains without a configured TTD, or if the head hasn't
+// reached it yet.
+func resolveMergeTransitionBlock(tx kv.RwTx, cfg IssuanceCfg, headNumber uint64) (uint64, bool, error) {
+	if cfg.chainConfig.TerminalTotalDifficulty == nil {
+		return 0, false, nil
+	}
+
+	if v, err := tx.GetOne(kv.MergeTransitionBlock, mergeTransitionKey); err != nil {
+		return 0, false, err
+	} else if v != nil {
+		blockNumber, err := dbutils.DecodeBlockNumber(v)
+		if err != nil {
+			return 0, false, err
+		}
+		return blockNumber, true, nil
+	}
+
+	// Resume the total-difficulty scan from wherever the last call left off
+	// instead of restarting at genesis: on a chain still below TTD, every
+	// SpawnStageIssuance call would otherwise re-walk all headers scanned so
+	// far, making this stage quadratic in the number of pre-merge blocks.
+	scanFrom := uint64(0)
+	if v, err := tx.GetOne(kv.MergeTransitionBlock, mergeScanProgressKey); err != nil {
+		return 0, false, err
+	} else if v != nil {
+		progress, err := dbutils.DecodeBlockNumber(v)
+		if err != nil {
+			return 0, false, err
+		}
+		scanFrom = progress + 1
+	}
+	if scanFrom > headNumber {
+		return 0, false, nil
+	}
 
+	headerC, err := tx.Cursor(kv.Headers)
+	if err != nil {
+		return 0, false, err
+	}
+	defer headerC.Close()
+
+	scanned := scanFrom
+	for k, _, err := headerC.Seek(dbutils.EncodeBlockNumber(scanFrom)); k != nil; k, _, err = headerC.Next() {
+		if err != nil {
+			return 0, false, err
+		}
 		if len(k) != 40 {
 			continue
 		}
-
-		currentBlockNumber, err = dbutils.DecodeBlockNumber(k[:8])
+		blockNumber, err := dbutils.DecodeBlockNumber(k[:8])
 		if err != nil {
-			return err
+			return 0, false, err
 		}
-		if currentBlockNumber > headNumber {
-			currentBlockNumber = headNumber
+		if blockNumber > headNumber {
 			break
 		}
-		// read body without transactions
-		hash, err := rawdb.ReadCanonicalHash(tx, currentBlockNumber)
+		scanned = blockNumber
+		hash := common.BytesToHash(k[8:])
+		td, err := rawdb.ReadTd(tx, hash, blockNumber)
 		if err != nil {
-			return err
+			return 0, false, err
 		}
-
-		if hash != common.BytesToHash(k[8:]) {
+		if td == nil {
 			continue
 		}
-		var header types.Header
-		if err := rlp.Decode(bytes.NewReader(v), &header); err != nil {
-			log.Error("Invalid block header RLP", "hash", hash, "err", err)
-			return nil
-		}
-
-		burnt := big.NewInt(0)
-		// burnt: len(Transactions) * baseFee * gasUsed
-		if header.BaseFee != nil {
-			burnt.Set(header.BaseFee)
-			burnt.Mul(burnt, big.NewInt(int64(header.GasUsed)))
-		}
-		// TotalIssued, BlockReward and UncleReward, depends on consensus engine
-		if header.Difficulty.Cmp(serenity.SerenityDifficulty) == 0 {
-			// Proof-of-stake is 0.3 ether per block
-			totalIssued.Add(totalIssued, serenity.RewardSerenity)
-		} else {
-			var blockReward uint256.Int
-			var uncleRewards []uint256.Int
-			if header.UncleHash == types.EmptyUncleHash {
-				blockReward, uncleRewards = ethash.AccumulateRewards(cfg.chainConfig, &header, nil)
-			} else {
-				body, err := cfg.blockReader.Body(ctx, tx, hash, currentBlockNumber)
-				if err != nil {
-					return err
-				}
-				blockReward, uncleRewards = ethash.AccumulateRewards(cfg.chainConfig, &header, body.Uncles)
-			}
-			// Set BlockReward
-			totalIssued.Add(totalIssued, blockReward.ToBig())
-			// Compute uncleRewards
-			for _, uncleReward := range uncleRewards {
-				totalIssued.Add(totalIssued, uncleReward.ToBig())
+		if td.Cmp(cfg.chainConfig.TerminalTotalDifficulty) >= 0 {
+			if err := tx.Put(kv.MergeTransitionBlock, mergeTransitionKey, dbutils.EncodeBlockNumber(blockNumber)); err != nil {
+				return 0, false, err
 			}
+			return blockNumber, true, nil
 		}
-		totalBurnt.Add(totalBurnt, burnt)
-		// Write to database
-		if err := rawdb.WriteTotalIssued(tx, currentBlockNumber, totalIssued); err != nil {
-			return err
-		}
-		if err := rawdb.WriteTotalBurnt(tx, currentBlockNumber, totalBurnt); err != nil {
-			return err
-		}
-		// Sleep and check for logs
-		timer := time.NewTimer(1 * time.Nanosecond)
-		select {
-		case <-ctx.Done():
-			stopped = true
-		case <-logEvery.C:
-			log.Info(fmt.Sprintf("[%s] Wrote Block Issuance", s.LogPrefix()),
-				"now", currentBlockNumber, "blk/sec", float64(currentBlockNumber-prevProgress)/float64(logInterval/time.Second))
-			prevProgress = currentBlockNumber
-		case <-timer.C:
-			log.Trace("RequestQueueTime (header) ticked")
-		}
-		// Cleanup timer
-		timer.Stop()
-	}
-	if err = s.Update(tx, currentBlockNumber); err != nil {
-		return err
 	}
-	if !useExternalTx {
-		if err = tx.Commit(); err != nil {
-			return err
-		}
+
+	if err := tx.Put(kv.MergeTransitionBlock, mergeScanProgressKey, dbutils.EncodeBlockNumber(scanned)); err != nil {
+		return 0, false, err
 	}
-	return nil
+	return 0, false, nil
+}
+
+// fakeExponential approximates factor * e**(numerator/denominator) using the
+// iterative Taylor-series expansion from EIP-4844, without floating point.
+func fakeExponential(factor, numerator, denominator *big.Int) *big.Int {
+	i := big.NewInt(1)
+	output := big.NewInt(0)
+	numeratorAccum := new(big.Int).Mul(factor, denominator)
+
+	for numeratorAccum.Sign() > 0 {
+		output.Add(output, numeratorAccum)
+
+		numeratorAccum.Mul(numeratorAccum, numerator)
+		numeratorAccum.Div(numeratorAccum, denominator)
+		numeratorAccum.Div(numeratorAccum, i)
+
+		i.Add(i, big.NewInt(1))
+	}
+	return output.Div(output, denominator)
 }
 
 func UnwindIssuanceStage(u *UnwindState, tx kv.RwTx, ctx context.Context) (err error) {
 	useExternalTx := tx != nil
 
+	if err = deleteIssuanceRecordsAfter(tx, kv.TotalIssued, u.UnwindPoint); err != nil {
+		return fmt.Errorf("removing TotalIssued past unwind point: %w", err)
+	}
+	if err = deleteIssuanceRecordsAfter(tx, kv.TotalBurnt, u.UnwindPoint); err != nil {
+		return fmt.Errorf("removing TotalBurnt past unwind point: %w", err)
+	}
+	if err = deleteIssuanceRecordsAfter(tx, kv.TotalWithdrawn, u.UnwindPoint); err != nil {
+		return fmt.Errorf("removing TotalWithdrawn past unwind point: %w", err)
+	}
+	if err = deleteIssuanceRecordsAfter(tx, kv.TotalBlobBurnt, u.UnwindPoint); err != nil {
+		return fmt.Errorf("removing TotalBlobBurnt past unwind point: %w", err)
+	}
+
 	if err = u.Done(tx); err != nil {
 		return fmt.Errorf(" reset: %w", err)
 	}
@@ -197,11 +310,87 @@ func UnwindIssuanceStage(u *UnwindState, tx kv.RwTx, ctx context.Context) (err e
 	return nil
 }
 
-func PruneIssuanceStage(p *PruneState, tx kv.RwTx, ctx context.Context) (err error) {
-	if tx != nil {
+// deleteIssuanceRecordsAfter removes every key-by-block-number entry in bucket
+// whose block number is strictly greater than unwindPoint, so a reorg doesn't
+// leave stale cumulative totals for SpawnStageIssuance to read back as a baseline.
+func deleteIssuanceRecordsAfter(tx kv.RwTx, bucket string, unwindPoint uint64) error {
+	c, err := tx.RwCursor(bucket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for k, _, err := c.Seek(dbutils.EncodeBlockNumber(unwindPoint + 1)); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func PruneIssuanceStage(p *PruneState, tx kv.RwTx, cfg IssuanceCfg, ctx context.Context) (err error) {
+	useExternalTx := tx != nil
+	if !useExternalTx {
+		tx, err = cfg.db.BeginRw(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+
+	if cfg.pruneDistance != 0 && p.ForwardProgress > cfg.pruneDistance {
+		pruneTo := p.ForwardProgress - cfg.pruneDistance
+		if err = pruneIssuanceBucket(tx, kv.TotalIssued, pruneTo); err != nil {
+			return fmt.Errorf("pruning TotalIssued: %w", err)
+		}
+		if err = pruneIssuanceBucket(tx, kv.TotalBurnt, pruneTo); err != nil {
+			return fmt.Errorf("pruning TotalBurnt: %w", err)
+		}
+		if err = pruneIssuanceBucket(tx, kv.TotalWithdrawn, pruneTo); err != nil {
+			return fmt.Errorf("pruning TotalWithdrawn: %w", err)
+		}
+		if err = pruneIssuanceBucket(tx, kv.TotalBlobBurnt, pruneTo); err != nil {
+			return fmt.Errorf("pruning TotalBlobBurnt: %w", err)
+		}
+	}
+
+	if !useExternalTx {
 		if err = tx.Commit(); err != nil {
 			return err
 		}
 	}
 	return nil
+}
+
+// pruneIssuanceBucket removes entries older than pruneTo, keeping the most
+// recent record so later reads of the running total still have a baseline.
+func pruneIssuanceBucket(tx kv.RwTx, bucket string, pruneTo uint64) error {
+	if pruneTo == 0 {
+		return nil
+	}
+	c, err := tx.RwCursor(bucket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		blockNumber, err := dbutils.DecodeBlockNumber(k)
+		if err != nil {
+			return err
+		}
+		if blockNumber >= pruneTo {
+			break
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
\ No newline at end of file
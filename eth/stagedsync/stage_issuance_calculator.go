@@ -0,0 +1,131 @@
+package stagedsync
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/erigon/consensus/aura"
+	"github.com/ledgerwatch/erigon/consensus/bor"
+	"github.com/ledgerwatch/erigon/consensus/ethash"
+	"github.com/ledgerwatch/erigon/core"
+	"github.com/ledgerwatch/erigon/core/state"
+	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/params"
+)
+
+// IssuanceCalculator computes per-block issuance and burn so the issuance
+// stage reports correct cumulative totals regardless of which consensus
+// engine produced the block. Post-merge blocks never reach these methods:
+// SpawnStageIssuance handles the EIP-4895 withdrawal path itself once the
+// merge transition block is known.
+type IssuanceCalculator interface {
+	// BlockReward returns the ether minted for header, including any uncle
+	// rewards, in wei. An error means the reward could not be determined (for
+	// example a failed system-contract call on AuRa chains) and the caller
+	// must fail the stage run rather than commit a wrong cumulative total.
+	BlockReward(header *types.Header, uncles []*types.Header) (*big.Int, error)
+	// Burnt returns the ether permanently removed from supply by header's
+	// base fee. Engines without EIP-1559 (or that redirect the burn to
+	// validators instead of destroying it) return zero.
+	Burnt(header *types.Header, receipts types.Receipts) (*big.Int, error)
+}
+
+func baseFeeBurnt(header *types.Header) *big.Int {
+	burnt := big.NewInt(0)
+	if header.BaseFee != nil {
+		burnt.Set(header.BaseFee)
+		burnt.Mul(burnt, big.NewInt(int64(header.GasUsed)))
+	}
+	return burnt
+}
+
+// EthashIssuanceCalculator is the original proof-of-work issuance schedule:
+// a fixed block reward plus uncle rewards, and (post-London) the EIP-1559
+// base fee burnt outright.
+type EthashIssuanceCalculator struct {
+	ChainConfig *params.ChainConfig
+}
+
+func (c EthashIssuanceCalculator) BlockReward(header *types.Header, uncles []*types.Header) (*big.Int, error) {
+	blockReward, uncleRewards := ethash.AccumulateRewards(c.ChainConfig, header, uncles)
+	total := blockReward.ToBig()
+	for _, uncleReward := range uncleRewards {
+		total.Add(total, uncleReward.ToBig())
+	}
+	return total, nil
+}
+
+func (c EthashIssuanceCalculator) Burnt(header *types.Header, receipts types.Receipts) (*big.Int, error) {
+	return baseFeeBurnt(header), nil
+}
+
+// CliqueIssuanceCalculator covers Clique PoA testnets: signers are not paid a
+// block subsidy, so the only supply change is the EIP-1559 burn once a chain
+// enables it.
+type CliqueIssuanceCalculator struct{}
+
+func (CliqueIssuanceCalculator) BlockReward(header *types.Header, uncles []*types.Header) (*big.Int, error) {
+	return big.NewInt(0), nil
+}
+
+func (CliqueIssuanceCalculator) Burnt(header *types.Header, receipts types.Receipts) (*big.Int, error) {
+	return baseFeeBurnt(header), nil
+}
+
+// BorIssuanceCalculator covers Polygon PoS: like Clique there's no native
+// block subsidy (MATIC supply is fixed at genesis), but the validator set
+// runs its own EIP-1559 fee split rather than Ethereum's plain burn. The
+// fraction actually destroyed (vs. redirected to validators) is governed by
+// the fee-split recorded in Bor's state-sync/validator-set contract, which
+// CalculateBurntFraction resolves from the chain config for the block's span.
+type BorIssuanceCalculator struct {
+	ChainConfig *params.ChainConfig
+	BorConfig   *bor.BorConfig
+}
+
+func (BorIssuanceCalculator) BlockReward(header *types.Header, uncles []*types.Header) (*big.Int, error) {
+	return big.NewInt(0), nil
+}
+
+func (c BorIssuanceCalculator) Burnt(header *types.Header, receipts types.Receipts) (*big.Int, error) {
+	burnt := baseFeeBurnt(header)
+	if burnt.Sign() == 0 || c.BorConfig == nil {
+		return burnt, nil
+	}
+	fraction := c.BorConfig.CalculateBurntFraction(header.Number.Uint64())
+	burnt.Mul(burnt, fraction.Num())
+	burnt.Div(burnt, fraction.Denom())
+	return burnt, nil
+}
+
+// AuraIssuanceCalculator covers AuRa chains such as Gnosis Chain, where block
+// rewards aren't a fixed constant but come from a block-reward system
+// contract invoked at the end of each block. StateAt must return the state
+// at header's parent for the call to see the correct contract storage.
+type AuraIssuanceCalculator struct {
+	ChainConfig *params.ChainConfig
+	AuraConfig  *aura.AuRaConfig
+	StateAt     func(header *types.Header) (*state.IntraBlockState, error)
+}
+
+func (c AuraIssuanceCalculator) BlockReward(header *types.Header, uncles []*types.Header) (*big.Int, error) {
+	if c.AuraConfig == nil || c.AuraConfig.BlockRewardContractAddress == nil {
+		return big.NewInt(0), nil
+	}
+	ibs, err := c.StateAt(header)
+	if err != nil {
+		return nil, fmt.Errorf("reading state for block reward contract at block %d: %w", header.Number.Uint64(), err)
+	}
+	reward, err := core.SysCallContract(*c.AuraConfig.BlockRewardContractAddress, aura.BlockRewardCallData(header.Coinbase), c.ChainConfig, ibs, header, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("calling block reward contract at block %d: %w", header.Number.Uint64(), err)
+	}
+	if len(reward) == 0 {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).SetBytes(reward), nil
+}
+
+func (c AuraIssuanceCalculator) Burnt(header *types.Header, receipts types.Receipts) (*big.Int, error) {
+	return baseFeeBurnt(header), nil
+}
@@ -0,0 +1,247 @@
+package stagedsync
+
+import (
+	"context"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon/core/rawdb"
+	"github.com/ledgerwatch/erigon/core/types"
+)
+
+// issuanceBlockResult is everything SpawnStageIssuance's writer needs to fold
+// a single block into the running cumulative totals. Computing these doesn't
+// require the running totals themselves, which is what makes it safe to
+// compute blocks out of order across worker goroutines.
+type issuanceBlockResult struct {
+	blockReward *big.Int
+	burnt       *big.Int
+	blobBurnt   *big.Int
+	withdrawn   *big.Int
+}
+
+type issuanceWindow struct {
+	start, end uint64 // inclusive
+}
+
+// issuanceWindows splits [from, to] into consecutive, inclusive,
+// issuanceChunkSize-sized windows in ascending order, with the final window
+// truncated to end at to. Returns nil if from > to.
+func issuanceWindows(from, to uint64) []issuanceWindow {
+	if from > to {
+		return nil
+	}
+	var windows []issuanceWindow
+	for start := from; start <= to; start += issuanceChunkSize {
+		end := start + issuanceChunkSize - 1
+		if end > to {
+			end = to
+		}
+		windows = append(windows, issuanceWindow{start, end})
+	}
+	return windows
+}
+
+// issuanceWindowResult is what a worker hands back for one window: either
+// its per-block results, or the error it hit while computing them.
+type issuanceWindowResult struct {
+	results []issuanceBlockResult
+	err     error
+}
+
+// streamIssuanceRange computes issuanceBlockResult for every block in
+// [from, to], fanning fixed-size windows out to a bounded pool of worker
+// goroutines (one read-only tx each), and invokes onWindow once per window
+// *in block order* as soon as that window is ready. Workers may race ahead
+// and compute further windows while onWindow is still processing an earlier
+// one, but at most `workers` windows are ever held in memory at once - unlike
+// materializing the whole [from, to] range up front, this keeps memory
+// bounded and lets the caller log/write progress as windows complete instead
+// of only after the entire range has been computed.
+func streamIssuanceRange(ctx context.Context, cfg IssuanceCfg, mergeBlock uint64, mergeKnown bool, from, to uint64, onWindow func(start uint64, results []issuanceBlockResult) error) error {
+	if from > to {
+		return nil
+	}
+
+	windows := issuanceWindows(from, to)
+
+	workers := runtime.NumCPU()
+	if workers > len(windows) {
+		workers = len(windows)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Open every worker's read tx up front so a BeginRo failure can't leave
+	// a window's result channel waiting forever for a worker that bailed
+	// before claiming any window.
+	roTxs := make([]kv.Tx, workers)
+	for i := 0; i < workers; i++ {
+		t, err := cfg.db.BeginRo(ctx)
+		if err != nil {
+			for _, prior := range roTxs[:i] {
+				prior.Rollback()
+			}
+			return err
+		}
+		roTxs[i] = t
+	}
+	defer func() {
+		for _, t := range roTxs {
+			t.Rollback()
+		}
+	}()
+
+	windowDone := make([]chan issuanceWindowResult, len(windows))
+	for i := range windowDone {
+		windowDone[i] = make(chan issuanceWindowResult, 1)
+	}
+
+	// slots bounds the windows claimed but not yet handed to onWindow to
+	// `workers`; done releases workers waiting for a slot once we return.
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+
+	var (
+		nextWindow int64 = -1
+		wg         sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(roTx kv.Tx) {
+			defer wg.Done()
+			for {
+				select {
+				case slots <- struct{}{}:
+				case <-done:
+					return
+				}
+				idx := atomic.AddInt64(&nextWindow, 1)
+				if int(idx) >= len(windows) {
+					return
+				}
+				w := windows[idx]
+				out := make([]issuanceBlockResult, w.end-w.start+1)
+				err := computeIssuanceWindow(ctx, roTx, cfg, mergeBlock, mergeKnown, w, out)
+				windowDone[idx] <- issuanceWindowResult{results: out, err: err}
+			}
+		}(roTxs[i])
+	}
+	defer wg.Wait()
+	defer close(done)
+
+	for i, w := range windows {
+		res := <-windowDone[i]
+		if res.err != nil {
+			if issuanceStreamStopped(ctx, res.err) {
+				return nil
+			}
+			return res.err
+		}
+		if err := onWindow(w.start, res.results); err != nil {
+			return err
+		}
+		<-slots
+	}
+	return nil
+}
+
+// issuanceStreamStopped reports whether a window's error is just the tail end
+// of ctx being cancelled - a graceful stop whose caller should keep whatever
+// windows were already handed to onWindow - rather than a genuine failure
+// that should discard the whole call's progress. Once ctx is done, every
+// worker still in flight will shortly report some error (usually
+// context.Canceled, but Cancel races a worker's own DB/read error too), so we
+// treat any error observed after cancellation as part of that same stop.
+func issuanceStreamStopped(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() != nil
+}
+
+// computeIssuanceWindow fills out with one issuanceBlockResult per block in
+// w, reading headers and (when needed) bodies from tx.
+func computeIssuanceWindow(ctx context.Context, tx kv.Tx, cfg IssuanceCfg, mergeBlock uint64, mergeKnown bool, w issuanceWindow, out []issuanceBlockResult) error {
+	for n := w.start; n <= w.end; n++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		r, err := computeIssuanceBlock(ctx, tx, cfg, mergeBlock, mergeKnown, n)
+		if err != nil {
+			return err
+		}
+		out[n-w.start] = r
+	}
+	return nil
+}
+
+func computeIssuanceBlock(ctx context.Context, tx kv.Tx, cfg IssuanceCfg, mergeBlock uint64, mergeKnown bool, blockNumber uint64) (issuanceBlockResult, error) {
+	zero := issuanceBlockResult{blockReward: big.NewInt(0), burnt: big.NewInt(0), blobBurnt: big.NewInt(0), withdrawn: big.NewInt(0)}
+
+	hash, err := rawdb.ReadCanonicalHash(tx, blockNumber)
+	if err != nil {
+		return zero, err
+	}
+	header, err := cfg.blockReader.Header(ctx, tx, hash, blockNumber)
+	if err != nil {
+		return zero, err
+	}
+	if header == nil {
+		return zero, nil
+	}
+
+	blobBurnt := big.NewInt(0)
+	// post-Cancun, blob transactions also permanently burn
+	// blobBaseFee(header.ExcessBlobGas) * header.BlobGasUsed
+	if header.ExcessBlobGas != nil && header.BlobGasUsed != nil {
+		blobBaseFee := fakeExponential(minBlobBaseFee, new(big.Int).SetUint64(*header.ExcessBlobGas), blobBaseFeeUpdateFraction)
+		blobBurnt.Mul(blobBaseFee, new(big.Int).SetUint64(*header.BlobGasUsed))
+	}
+
+	blockReward := big.NewInt(0)
+	withdrawn := big.NewInt(0)
+	var burnt *big.Int
+
+	// Post-merge (TTD reached), EL issuance is zero and validator issuance
+	// instead arrives as EIP-4895 withdrawals carried in the block body.
+	if mergeKnown && blockNumber >= mergeBlock {
+		body, err := cfg.blockReader.Body(ctx, tx, hash, blockNumber)
+		if err != nil {
+			return zero, err
+		}
+		for _, w := range body.Withdrawals {
+			withdrawnWei := new(big.Int).Mul(new(big.Int).SetUint64(w.Amount), gweiToWei)
+			withdrawn.Add(withdrawn, withdrawnWei)
+		}
+		burnt = baseFeeBurnt(header)
+	} else {
+		var uncles []*types.Header
+		if header.UncleHash != types.EmptyUncleHash {
+			body, err := cfg.blockReader.Body(ctx, tx, hash, blockNumber)
+			if err != nil {
+				return zero, err
+			}
+			uncles = body.Uncles
+		}
+		blockReward, err = cfg.calculator.BlockReward(header, uncles)
+		if err != nil {
+			return zero, err
+		}
+		burnt, err = cfg.calculator.Burnt(header, nil)
+		if err != nil {
+			return zero, err
+		}
+	}
+
+	return issuanceBlockResult{
+		blockReward: blockReward,
+		burnt:       burnt,
+		blobBurnt:   blobBurnt,
+		withdrawn:   withdrawn,
+	}, nil
+}
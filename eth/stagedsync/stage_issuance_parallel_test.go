@@ -0,0 +1,93 @@
+package stagedsync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIssuanceWindows(t *testing.T) {
+	cases := []struct {
+		name     string
+		from, to uint64
+		want     []issuanceWindow
+	}{
+		{"empty range (from > to)", 5, 4, nil},
+		{"single block", 100, 100, []issuanceWindow{{100, 100}}},
+		{"exact one chunk", 0, issuanceChunkSize - 1, []issuanceWindow{{0, issuanceChunkSize - 1}}},
+		{"one chunk plus one block", 0, issuanceChunkSize, []issuanceWindow{
+			{0, issuanceChunkSize - 1},
+			{issuanceChunkSize, issuanceChunkSize},
+		}},
+		{"exactly two chunks", 0, 2*issuanceChunkSize - 1, []issuanceWindow{
+			{0, issuanceChunkSize - 1},
+			{issuanceChunkSize, 2*issuanceChunkSize - 1},
+		}},
+		{"non-zero start, partial last window", 10, 10 + issuanceChunkSize + 4, []issuanceWindow{
+			{10, 10 + issuanceChunkSize - 1},
+			{10 + issuanceChunkSize, 10 + issuanceChunkSize + 4},
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := issuanceWindows(c.from, c.to)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("issuanceWindows(%d, %d) = %+v, want %+v", c.from, c.to, got, c.want)
+			}
+			// Windows must be contiguous, ascending, and cover [from, to]
+			// exactly once each - the property streamIssuanceRange relies on
+			// to write/log results in block order.
+			for i, w := range got {
+				if w.start > w.end {
+					t.Fatalf("window %d has start %d > end %d", i, w.start, w.end)
+				}
+				if i > 0 && w.start != got[i-1].end+1 {
+					t.Fatalf("window %d starts at %d, want %d (immediately after previous window)", i, w.start, got[i-1].end+1)
+				}
+			}
+			if len(got) > 0 && got[len(got)-1].end != c.to {
+				t.Fatalf("last window ends at %d, want %d", got[len(got)-1].end, c.to)
+			}
+		})
+	}
+}
+
+// TestComputeIssuanceWindowCancellation checks that an already-cancelled
+// context stops computeIssuanceWindow before it touches cfg.blockReader, so
+// cancelling mid-window doesn't run (or panic on) further block lookups.
+func TestComputeIssuanceWindowCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	out := make([]issuanceBlockResult, 10)
+	err := computeIssuanceWindow(ctx, nil, IssuanceCfg{}, 0, false, issuanceWindow{start: 100, end: 109}, out)
+	if err != context.Canceled {
+		t.Fatalf("computeIssuanceWindow with cancelled ctx returned %v, want context.Canceled", err)
+	}
+}
+
+// TestIssuanceStreamStopped checks that streamIssuanceRange only treats a
+// window error as a graceful, progress-preserving stop once ctx is actually
+// cancelled - an unrelated error arriving before that point must still fail
+// the call, or a real failure would get silently swallowed.
+func TestIssuanceStreamStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if issuanceStreamStopped(ctx, errors.New("boom")) {
+		t.Fatal("an error before cancellation must not be treated as a graceful stop")
+	}
+	if issuanceStreamStopped(ctx, nil) {
+		t.Fatal("a nil error must never be treated as a stop")
+	}
+
+	cancel()
+
+	if !issuanceStreamStopped(ctx, context.Canceled) {
+		t.Fatal("context.Canceled after cancellation must be treated as a graceful stop")
+	}
+	if !issuanceStreamStopped(ctx, errors.New("boom")) {
+		t.Fatal("any window error observed after cancellation must be treated as part of the same stop")
+	}
+}
@@ -0,0 +1,38 @@
+package stagedsync
+
+import (
+	"math/big"
+	"testing"
+)
+
+// TestFakeExponential checks fakeExponential against the published EIP-4844
+// fake_exponential reference test vectors.
+func TestFakeExponential(t *testing.T) {
+	cases := []struct {
+		factor, numerator, denominator int64
+		want                           int64
+	}{
+		{1, 0, 1, 1},
+		{38493, 0, 1000, 38493},
+		{0, 1234, 2345, 0},
+		{1, 2, 1, 6},
+		{1, 4, 2, 6},
+		{1, 3, 1, 16},
+		{1, 6, 2, 18},
+		{1, 4, 1, 49},
+		{1, 8, 2, 50},
+		{10, 8, 2, 542},
+		{1, 5, 1, 136},
+		{1, 5, 2, 11},
+		{2, 5, 2, 23},
+		{1, 50000000, 2225652, 5709098764},
+		{1, 380928, 888602, 1},
+	}
+
+	for _, c := range cases {
+		got := fakeExponential(big.NewInt(c.factor), big.NewInt(c.numerator), big.NewInt(c.denominator))
+		if got.Cmp(big.NewInt(c.want)) != 0 {
+			t.Errorf("fakeExponential(%d, %d, %d) = %s, want %d", c.factor, c.numerator, c.denominator, got, c.want)
+		}
+	}
+}